@@ -7,6 +7,7 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -15,6 +16,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/magefile/mage/mg"
@@ -33,14 +35,13 @@ var errCommitFormatting = errors.New("files not formatted, please commit formatt
 var errNoGitDir = errors.New("no .git directory found")
 
 func init() {
-	for _, check := range []func() error{
-		checkTinygoVersion,
-		checkGoVersion,
-	} {
-		if err := check(); err != nil {
-			fmt.Printf("Error: %v\n", err)
-			os.Exit(1)
-		}
+	// checkTinygoVersion is deliberately not run here: it would os.Exit before any target ran,
+	// including Build's Docker-based fallback and targets that don't touch tinygo at all (Lint,
+	// Test, PublishOCI, UpdateLibs, ...). Targets that actually require tinygo check it
+	// themselves (BuildLocal), and Build's canBuildLocal check already falls back gracefully.
+	if err := checkGoVersion(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
 	}
 }
 
@@ -163,30 +164,69 @@ func Check() {
 	mg.SerialDeps(Lint, Test)
 }
 
-// Build builds the Coraza wasm plugin.
-func Build() error {
-	if err := os.MkdirAll("build", 0755); err != nil {
-		return err
-	}
+// requiredLibs are the prebuilt static libraries BuildLocal expects under ./lib.
+var requiredLibs = []string{"libaho_corasick.a", "libinjection.a", "libmimalloc.a", "libre2.a"}
 
-	buildTags := []string{"custommalloc"}
+// buildTags computes the tinygo build tags from the TIMING/MEMSTATS/METRICS env vars.
+func buildTags() []string {
+	tags := []string{"custommalloc"}
 	if os.Getenv("TIMING") == "true" {
-		buildTags = append(buildTags, "timing", "proxywasm_timing")
+		tags = append(tags, "timing", "proxywasm_timing")
 	}
 	if os.Getenv("MEMSTATS") == "true" {
-		buildTags = append(buildTags, "memstats")
+		tags = append(tags, "memstats")
 	}
+	if os.Getenv("METRICS") == "true" {
+		tags = append(tags, "metrics")
+	}
+	return tags
+}
 
-	buildTagArg := fmt.Sprintf("-tags='%s'", strings.Join(buildTags, " "))
-
+// initialPages computes the wasm memory's initial page count from the INITIAL_PAGES env var.
+func initialPages() (int, error) {
 	// ~100MB initial heap
-	initialPages := 2100
+	pages := 2100
 	if ipEnv := os.Getenv("INITIAL_PAGES"); ipEnv != "" {
-		if ip, err := strconv.Atoi(ipEnv); err != nil {
-			return err
-		} else {
-			initialPages = ip
+		ip, err := strconv.Atoi(ipEnv)
+		if err != nil {
+			return 0, err
 		}
+		pages = ip
+	}
+	return pages, nil
+}
+
+// canBuildLocal reports whether this host can run BuildLocal: tinygo must be on PATH and every
+// lib in requiredLibs must be present under ./lib.
+func canBuildLocal() bool {
+	if err := checkTinygoVersion(); err != nil {
+		return false
+	}
+	for _, lib := range requiredLibs {
+		if _, err := os.Stat(filepath.Join("lib", lib)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Build builds the Coraza wasm plugin, preferring the dockerless BuildLocal path when tinygo
+// and the prebuilt libs are available on the host, and falling back to the Docker-based
+// buildtools-tinygo image otherwise.
+func Build() error {
+	if err := os.MkdirAll("build", 0755); err != nil {
+		return err
+	}
+
+	if canBuildLocal() {
+		return BuildLocal()
+	}
+
+	buildTagArg := fmt.Sprintf("-tags='%s'", strings.Join(buildTags(), " "))
+
+	pages, err := initialPages()
+	if err != nil {
+		return err
 	}
 
 	wd, err := os.Getwd()
@@ -202,24 +242,303 @@ tinygo build -gc=none -opt=2 -o %s -scheduler=none -target=wasi %s`, filepath.Jo
 		return err
 	}
 
-	return patchWasm(filepath.Join("build", "mainraw.wasm"), filepath.Join("build", "main.wasm"), initialPages)
+	return patchWasm(filepath.Join("build", "mainraw.wasm"), filepath.Join("build", "main.wasm"), pages)
+}
+
+// BuildLocal builds the Coraza wasm plugin by invoking tinygo directly on the host, linking
+// against the prebuilt static libs in ./lib instead of the buildtools-tinygo Docker image. This
+// is faster for incremental local development and works on hosts without Docker, but requires
+// the host's tinygo version and libs to match what buildtools-tinygo ships.
+func BuildLocal() error {
+	if err := checkTinygoVersion(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll("build", 0755); err != nil {
+		return err
+	}
+
+	for _, lib := range requiredLibs {
+		if _, err := os.Stat(filepath.Join("lib", lib)); err != nil {
+			return fmt.Errorf("missing %s, run mage UpdateLibs first: %v", lib, err)
+		}
+	}
+
+	pages, err := initialPages()
+	if err != nil {
+		return err
+	}
+
+	rawPath := filepath.Join("build", "mainraw.wasm")
+	args := []string{
+		"build",
+		"-gc=none",
+		"-opt=2",
+		"-o", rawPath,
+		"-scheduler=none",
+		"-target=wasi",
+		"-tags", strings.Join(buildTags(), " "),
+	}
+	if err := sh.RunV("tinygo", args...); err != nil {
+		return err
+	}
+
+	return patchWasm(rawPath, filepath.Join("build", "main.wasm"), pages)
+}
+
+// wasmConfigMediaType is the media type of the OCI config blob describing the wasm module,
+// following the Wasm OCI Artifact spec used by Istio WasmPlugin and Envoy.
+const wasmConfigMediaType = "application/vnd.module.wasm.config.v1+json"
+
+// wasmLayerMediaType is the media type of the OCI layer blob carrying the compiled wasm bytes.
+const wasmLayerMediaType = "application/vnd.module.wasm.content.layer.v1+wasm"
+
+// wasmModuleConfig is the JSON payload of the OCI config blob. It is intentionally minimal,
+// mirroring what solo-io/wasm images and Istio's WasmPlugin resolver expect.
+type wasmModuleConfig struct {
+	Type         string            `json:"type"`
+	LayerDigests []string          `json:"layerDigests"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// PublishOCI packages build/main.wasm as an OCI artifact and pushes it to a registry so it can
+// be referenced as oci://... from an Istio WasmPlugin or Envoy ExtensionConfig. Requires the
+// wasm to have been produced by Build (respecting TIMING/MEMSTATS/INITIAL_PAGES) and the `oras`
+// CLI to be on PATH.
+//
+// TODO: e2e/istio/wasmplugin.yaml still kind-loads the docker image rather than pulling an OCI
+// artifact published by this target; wiring that up is out of scope here since this checkout
+// doesn't carry an e2e/ directory.
+func PublishOCI() error {
+	mg.SerialDeps(Build)
+
+	tag := os.Getenv("TAG")
+	if tag == "" {
+		return errors.New("TAG must be set to the destination reference, e.g. ghcr.io/corazawaf/coraza-proxy-wasm:latest")
+	}
+
+	wasmPath := filepath.Join("build", "main.wasm")
+	layerDigest, err := sh.Output("sha256sum", wasmPath)
+	if err != nil {
+		return fmt.Errorf("unexpected sha256sum error: %v", err)
+	}
+	layerDigest = "sha256:" + strings.Fields(layerDigest)[0]
+
+	annotations := map[string]string{}
+	if crsVersion := os.Getenv("CRS_VERSION"); crsVersion != "" {
+		annotations["io.coraza.crs.version"] = crsVersion
+	}
+	annotations["io.coraza.build.tags"] = strings.Join(buildTags(), ",")
+	for _, kv := range strings.Split(os.Getenv("ANNOTATIONS"), ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			continue
+		}
+		annotations[k] = v
+	}
+
+	config := wasmModuleConfig{
+		Type:         "oci",
+		LayerDigests: []string{layerDigest},
+		Annotations:  annotations,
+	}
+	configPath := filepath.Join("build", "oci-config.json")
+	configJSON, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(configPath, configJSON, 0644); err != nil {
+		return err
+	}
+
+	annotationArgs := []string{}
+	for k, v := range annotations {
+		annotationArgs = append(annotationArgs, "--annotation", fmt.Sprintf("%s=%s", k, v))
+	}
+
+	args := append([]string{
+		"push", tag,
+		"--config", fmt.Sprintf("%s:%s", configPath, wasmConfigMediaType),
+		fmt.Sprintf("%s:%s", wasmPath, wasmLayerMediaType),
+	}, annotationArgs...)
+	if err := sh.RunV("oras", args...); err != nil {
+		return fmt.Errorf("oras push failed: %v", err)
+	}
+
+	if os.Getenv("SIGN") == "true" {
+		if err := sh.RunV("cosign", "sign", "--yes", tag); err != nil {
+			return fmt.Errorf("cosign sign failed: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// libs are the C++ filter dependencies, keyed the same way as buildtools/versions.json and the
+// buildtools/<lib> Dockerfile directories.
+var libs = []string{"aho-corasick", "libinjection", "mimalloc", "re2"}
+
+// versionsManifestPath is the per-lib pin file UpdateLibs builds against and VerifyLibs checks
+// artifacts against.
+const versionsManifestPath = "buildtools/versions.json"
+
+// libVersion pins one lib to an upstream commit/tag and the sha256 of the .a it must produce, so
+// "the same commit of this repo" always produces byte-identical native deps.
+type libVersion struct {
+	Repo     string `json:"repo"`
+	Ref      string `json:"ref"`
+	Artifact string `json:"artifact"`
+	SHA256   string `json:"sha256"`
+}
+
+func loadLibVersions() (map[string]libVersion, error) {
+	data, err := os.ReadFile(versionsManifestPath)
+	if err != nil {
+		return nil, err
+	}
+	var versions map[string]libVersion
+	if err := json.Unmarshal(data, &versions); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", versionsManifestPath, err)
+	}
+	return versions, nil
+}
+
+func sha256File(path string) (string, error) {
+	sum, err := sh.Output("sha256sum", path)
+	if err != nil {
+		return "", err
+	}
+	return strings.Fields(sum)[0], nil
+}
+
+// libVersionsMu serializes read-modify-write access to versionsManifestPath, since UpdateLibs
+// runs buildLib for every lib concurrently via mg.Deps.
+var libVersionsMu sync.Mutex
+
+// pinLibSHA256 records got as lib's sha256 in versionsManifestPath, for the first build of a lib
+// that has no pin yet.
+func pinLibSHA256(lib, got string) error {
+	libVersionsMu.Lock()
+	defer libVersionsMu.Unlock()
+
+	versions, err := loadLibVersions()
+	if err != nil {
+		return err
+	}
+	version := versions[lib]
+	version.SHA256 = got
+	versions[lib] = version
+
+	data, err := json.MarshalIndent(versions, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(versionsManifestPath, append(data, '\n'), 0o644)
+}
+
+// buildLib builds a single lib's Docker image, pinned to its manifest ref via --build-arg, runs
+// it to produce the .a under ./lib, and verifies the result against the manifest's sha256,
+// failing loudly on drift. A lib with no pin yet records the sha256 of this build instead of
+// failing, so the first UpdateLibs run bootstraps buildtools/versions.json and every run after
+// that enforces reproducibility.
+func buildLib(lib string) error {
+	libVersionsMu.Lock()
+	versions, err := loadLibVersions()
+	libVersionsMu.Unlock()
+	if err != nil {
+		return err
+	}
+	version, ok := versions[lib]
+	if !ok {
+		return fmt.Errorf("%s has no entry in %s", lib, versionsManifestPath)
+	}
+
+	image := "ghcr.io/corazawaf/coraza-proxy-wasm/buildtools-" + lib
+	if err := sh.RunV("docker", "build",
+		"--build-arg", "REPO="+version.Repo,
+		"--build-arg", "REF="+version.Ref,
+		"-t", image, filepath.Join("buildtools", lib)); err != nil {
+		return err
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if err := sh.RunV("docker", "run", "--rm", "-v", fmt.Sprintf("%s:/out", filepath.Join(wd, "lib")), image); err != nil {
+		return err
+	}
+
+	got, err := sha256File(filepath.Join("lib", version.Artifact))
+	if err != nil {
+		return err
+	}
+
+	if version.SHA256 == "" {
+		if err := pinLibSHA256(lib, got); err != nil {
+			return fmt.Errorf("%s: failed recording computed sha256 %s: %v", lib, got, err)
+		}
+		fmt.Printf("%s: no pinned sha256 yet, recorded %s in %s\n", lib, got, versionsManifestPath)
+		return nil
+	}
+	if got != version.SHA256 {
+		return fmt.Errorf("%s: sha256 drift, manifest wants %s, got %s", lib, version.SHA256, got)
+	}
+
+	return nil
 }
 
-// UpdateLibs updates the C++ filter dependencies.
+// UpdateLibs rebuilds the C++ filter dependencies in parallel, each pinned to the commit/tag
+// recorded in buildtools/versions.json. A lib with no sha256 pin yet has one recorded from this
+// build; every lib that already has one fails loudly if the rebuilt artifact doesn't match it.
 func UpdateLibs() error {
-	libs := []string{"aho-corasick", "libinjection", "mimalloc", "re2"}
+	if _, err := loadLibVersions(); err != nil {
+		return err
+	}
+
+	deps := make([]interface{}, len(libs))
+	for i, lib := range libs {
+		deps[i] = mg.F(buildLib, lib)
+	}
+	mg.Deps(deps...)
+
+	return nil
+}
+
+// VerifyLibs checks the on-disk lib/*.a artifacts against buildtools/versions.json without
+// rebuilding anything, so CI can gate PRs on reproducible native deps once every lib has a pin
+// (run UpdateLibs at least once to populate buildtools/versions.json; it is not yet wired into
+// Check/Lint, since none of this repo's libs are pinned at the time of writing).
+func VerifyLibs() error {
+	versions, err := loadLibVersions()
+	if err != nil {
+		return err
+	}
+
+	var drifted []string
 	for _, lib := range libs {
-		if err := sh.RunV("docker", "build", "-t", "ghcr.io/corazawaf/coraza-proxy-wasm/buildtools-"+lib, filepath.Join("buildtools", lib)); err != nil {
-			return err
+		version, ok := versions[lib]
+		if !ok {
+			return fmt.Errorf("%s has no entry in %s", lib, versionsManifestPath)
+		}
+		if version.SHA256 == "" {
+			drifted = append(drifted, fmt.Sprintf("%s: no pinned sha256 in %s", lib, versionsManifestPath))
+			continue
 		}
-		wd, err := os.Getwd()
+
+		got, err := sha256File(filepath.Join("lib", version.Artifact))
 		if err != nil {
-			return err
+			return fmt.Errorf("%s: %v", lib, err)
 		}
-		if err := sh.RunV("docker", "run", "-it", "--rm", "-v", fmt.Sprintf("%s:/out", filepath.Join(wd, "lib")), "ghcr.io/corazawaf/coraza-proxy-wasm/buildtools-"+lib); err != nil {
-			return err
+		if got != version.SHA256 {
+			drifted = append(drifted, fmt.Sprintf("%s: manifest wants %s, got %s", lib, version.SHA256, got))
 		}
 	}
+
+	if len(drifted) > 0 {
+		return fmt.Errorf("lib artifacts drifted from %s:\n%s", versionsManifestPath, strings.Join(drifted, "\n"))
+	}
+
 	return nil
 }
 
@@ -0,0 +1,117 @@
+// Copyright The OWASP Coraza contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package wasmplugin
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// defaultRulesetName is the key under which the legacy top-level rules/metric_labels fields are
+// exposed in pluginConfiguration.rulesets, so a single deployed wasm without a "rulesets" entry
+// behaves exactly as it did before rulesets existed.
+const defaultRulesetName = "default"
+
+// rulesetConfiguration is a named set of SecRules directives and the metric labels its compiled
+// WAF instance should be tagged with.
+type rulesetConfiguration struct {
+	rules        []string
+	metricLabels map[string]string
+}
+
+type pluginConfiguration struct {
+	// rules and metricLabels are the implicit "default" ruleset, kept for back-compat with
+	// configs that predate rulesets.
+	rules        []string
+	metricLabels map[string]string
+
+	// rulesets holds every named ruleset, including defaultRuleset, keyed by name.
+	rulesets map[string]rulesetConfiguration
+	// defaultRuleset names the entry in rulesets to run when request-time selection finds no
+	// match.
+	defaultRuleset string
+
+	// directivesFiles names files under the wasm module's embedded directives FS to prepend to
+	// the rules of the defaultRuleset entry, loaded in the given order.
+	directivesFiles []string
+	// directivesURLs are HTTP(S) URLs fetched at OnPluginStart and prepended to the rules of the
+	// defaultRuleset entry, after directivesFiles, in the given order.
+	directivesURLs []string
+	// directivesURLsCluster is the Envoy cluster DispatchHttpCall uses to reach directivesURLs.
+	directivesURLsCluster string
+	// directivesURLsTimeoutMs bounds each directivesURLs fetch; 0 means defaultDirectivesURLsTimeoutMs.
+	directivesURLsTimeoutMs int
+}
+
+// jsonRuleset mirrors rulesetConfiguration for JSON decoding.
+type jsonRuleset struct {
+	Rules        []string          `json:"rules"`
+	MetricLabels map[string]string `json:"metric_labels"`
+}
+
+// jsonPluginConfiguration mirrors pluginConfiguration for JSON decoding.
+type jsonPluginConfiguration struct {
+	Rules          []string               `json:"rules"`
+	MetricLabels   map[string]string      `json:"metric_labels"`
+	Rulesets       map[string]jsonRuleset `json:"rulesets"`
+	DefaultRuleset string                 `json:"default_ruleset"`
+
+	DirectivesFiles         []string `json:"directives_files"`
+	DirectivesURLs          []string `json:"directives_urls"`
+	DirectivesURLsCluster   string   `json:"directives_urls_cluster"`
+	DirectivesURLsTimeoutMs int      `json:"directives_urls_timeout_ms"`
+}
+
+func parsePluginConfiguration(data []byte) (pluginConfiguration, error) {
+	config := pluginConfiguration{}
+
+	if len(data) == 0 {
+		return config, nil
+	}
+
+	var raw jsonPluginConfiguration
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return config, fmt.Errorf("invalid json: %q", string(data))
+	}
+
+	config.rules = []string{}
+	config.metricLabels = map[string]string{}
+	if raw.Rules != nil {
+		config.rules = raw.Rules
+	}
+	if raw.MetricLabels != nil {
+		config.metricLabels = raw.MetricLabels
+	}
+
+	rulesets := map[string]rulesetConfiguration{}
+	for name, rs := range raw.Rulesets {
+		rulesets[name] = rulesetConfiguration{rules: rs.Rules, metricLabels: rs.MetricLabels}
+	}
+
+	defaultRuleset := raw.DefaultRuleset
+	if defaultRuleset == "" {
+		defaultRuleset = defaultRulesetName
+	}
+
+	if _, exists := rulesets[defaultRulesetName]; exists {
+		if len(config.rules) > 0 || len(config.metricLabels) > 0 {
+			return config, fmt.Errorf("ruleset name collision: %q is both the implicit default ruleset and an explicit entry in \"rulesets\"", defaultRulesetName)
+		}
+	} else if len(config.rules) > 0 || len(config.metricLabels) > 0 || defaultRuleset == defaultRulesetName {
+		// Register the implicit default ruleset when top-level rules/metricLabels are set, and also
+		// when they are empty but defaultRuleset names it (e.g. a directives-only config), so a
+		// config without default_ruleset always has an entry for it.
+		rulesets[defaultRulesetName] = rulesetConfiguration{rules: config.rules, metricLabels: config.metricLabels}
+	}
+
+	config.rulesets = rulesets
+	config.defaultRuleset = defaultRuleset
+
+	config.directivesFiles = raw.DirectivesFiles
+	config.directivesURLs = raw.DirectivesURLs
+	config.directivesURLsCluster = raw.DirectivesURLsCluster
+	config.directivesURLsTimeoutMs = raw.DirectivesURLsTimeoutMs
+
+	return config, nil
+}
@@ -12,10 +12,16 @@ import (
 
 func TestParsePluginConfiguration(t *testing.T) {
 	testCases := []struct {
-		name         string
-		config       string
-		expectErr    error
-		expectConfig pluginConfiguration
+		name                    string
+		config                  string
+		expectErr               error
+		expectConfig            pluginConfiguration
+		expectRulesets          map[string]rulesetConfiguration
+		expectDefault           string
+		expectDirectivesFiles   []string
+		expectDirectivesURLs    []string
+		expectDirectivesCluster string
+		expectDirectivesTimeout int
 	}{
 		{
 			name: "empty config",
@@ -27,6 +33,10 @@ func TestParsePluginConfiguration(t *testing.T) {
 				rules:        []string{},
 				metricLabels: map[string]string{},
 			},
+			expectRulesets: map[string]rulesetConfiguration{
+				"default": {rules: []string{}, metricLabels: map[string]string{}},
+			},
+			expectDefault: "default",
 		},
 		{
 			name:      "bad config",
@@ -44,11 +54,15 @@ func TestParsePluginConfiguration(t *testing.T) {
 				rules:        []string{"SecRuleEngine On"},
 				metricLabels: map[string]string{},
 			},
+			expectRulesets: map[string]rulesetConfiguration{
+				"default": {rules: []string{"SecRuleEngine On"}, metricLabels: map[string]string{}},
+			},
+			expectDefault: "default",
 		},
 		{
 			name: "inline many entries",
 			config: `
-			{ 
+			{
 				"rules": ["SecRuleEngine On", "Include @owasp_crs/*.conf\nSecRule REQUEST_URI \"@streq /admin\" \"id:101,phase:1,t:lowercase,deny\""]
 			}
 			`,
@@ -60,7 +74,7 @@ func TestParsePluginConfiguration(t *testing.T) {
 		{
 			name: "metrics label",
 			config: `
-			{ 
+			{
 				"rules": ["SecRuleEngine On", "Include @owasp_crs/*.conf\nSecRule REQUEST_URI \"@streq /admin\" \"id:101,phase:1,t:lowercase,deny\""],
 				"metric_labels": {"owner": "coraza","identifier": "global"}
 			}
@@ -73,6 +87,113 @@ func TestParsePluginConfiguration(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "named rulesets with implicit default",
+			config: `
+			{
+				"rules": ["SecRuleEngine On"],
+				"rulesets": {
+					"admin": {"rules": ["SecRuleEngine On", "Include @admin-rules.conf"], "metric_labels": {"ruleset": "admin"}}
+				}
+			}
+			`,
+			expectConfig: pluginConfiguration{
+				rules:        []string{"SecRuleEngine On"},
+				metricLabels: map[string]string{},
+			},
+			expectRulesets: map[string]rulesetConfiguration{
+				"default": {rules: []string{"SecRuleEngine On"}, metricLabels: map[string]string{}},
+				"admin":   {rules: []string{"SecRuleEngine On", "Include @admin-rules.conf"}, metricLabels: map[string]string{"ruleset": "admin"}},
+			},
+			expectDefault: "default",
+		},
+		{
+			name: "named rulesets with explicit default_ruleset",
+			config: `
+			{
+				"default_ruleset": "admin",
+				"rulesets": {
+					"admin": {"rules": ["SecRuleEngine On"]},
+					"public": {"rules": ["SecRuleEngine On", "SecRuleRemoveById 101"]}
+				}
+			}
+			`,
+			expectRulesets: map[string]rulesetConfiguration{
+				"admin":  {rules: []string{"SecRuleEngine On"}},
+				"public": {rules: []string{"SecRuleEngine On", "SecRuleRemoveById 101"}},
+			},
+			expectDefault: "admin",
+		},
+		{
+			name: "explicit default_ruleset with top-level rules keeps the implicit default separate",
+			config: `
+			{
+				"default_ruleset": "admin",
+				"rules": ["SecRuleEngine On"],
+				"rulesets": {
+					"admin": {"rules": ["SecRuleEngine On", "Include @admin-rules.conf"]}
+				}
+			}
+			`,
+			expectConfig: pluginConfiguration{
+				rules:        []string{"SecRuleEngine On"},
+				metricLabels: map[string]string{},
+			},
+			expectRulesets: map[string]rulesetConfiguration{
+				"default": {rules: []string{"SecRuleEngine On"}, metricLabels: map[string]string{}},
+				"admin":   {rules: []string{"SecRuleEngine On", "Include @admin-rules.conf"}},
+			},
+			expectDefault: "admin",
+		},
+		{
+			name: "ruleset name collision with implicit default",
+			config: `
+			{
+				"rules": ["SecRuleEngine On"],
+				"rulesets": {
+					"default": {"rules": ["SecRuleEngine On"]}
+				}
+			}
+			`,
+			expectErr: errors.New("ruleset name collision: \"default\" is both the implicit default ruleset and an explicit entry in \"rulesets\""),
+		},
+		{
+			name: "directives-only config still gets an implicit default ruleset",
+			config: `
+			{
+				"directives_files": ["crs-setup.conf", "crs.conf"]
+			}
+			`,
+			expectConfig: pluginConfiguration{
+				rules:        []string{},
+				metricLabels: map[string]string{},
+			},
+			expectRulesets: map[string]rulesetConfiguration{
+				"default": {rules: []string{}, metricLabels: map[string]string{}},
+			},
+			expectDefault:         "default",
+			expectDirectivesFiles: []string{"crs-setup.conf", "crs.conf"},
+		},
+		{
+			name: "mixed directives sources",
+			config: `
+			{
+				"directives_files": ["crs-setup.conf", "crs.conf"],
+				"directives_urls": ["https://example.com/custom-rules.conf"],
+				"directives_urls_cluster": "directives_upstream",
+				"directives_urls_timeout_ms": 2000,
+				"rules": ["SecRuleEngine On"]
+			}
+			`,
+			expectConfig: pluginConfiguration{
+				rules:        []string{"SecRuleEngine On"},
+				metricLabels: map[string]string{},
+			},
+			expectDirectivesFiles:   []string{"crs-setup.conf", "crs.conf"},
+			expectDirectivesURLs:    []string{"https://example.com/custom-rules.conf"},
+			expectDirectivesCluster: "directives_upstream",
+			expectDirectivesTimeout: 2000,
+		},
 	}
 
 	for _, testCase := range testCases {
@@ -81,6 +202,16 @@ func TestParsePluginConfiguration(t *testing.T) {
 			assert.Equal(t, testCase.expectErr, err)
 			assert.ElementsMatch(t, testCase.expectConfig.rules, cfg.rules)
 			assert.Equal(t, testCase.expectConfig.metricLabels, cfg.metricLabels)
+			if testCase.expectRulesets != nil {
+				assert.Equal(t, testCase.expectRulesets, cfg.rulesets)
+				assert.Equal(t, testCase.expectDefault, cfg.defaultRuleset)
+			}
+			if testCase.expectDirectivesFiles != nil || testCase.expectDirectivesURLs != nil {
+				assert.Equal(t, testCase.expectDirectivesFiles, cfg.directivesFiles)
+				assert.Equal(t, testCase.expectDirectivesURLs, cfg.directivesURLs)
+				assert.Equal(t, testCase.expectDirectivesCluster, cfg.directivesURLsCluster)
+				assert.Equal(t, testCase.expectDirectivesTimeout, cfg.directivesURLsTimeoutMs)
+			}
 		})
 	}
 }
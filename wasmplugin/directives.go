@@ -0,0 +1,178 @@
+// Copyright The OWASP Coraza contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package wasmplugin
+
+import (
+	"embed"
+	"fmt"
+	"net/url"
+
+	"github.com/tetratelabs/proxy-wasm-go-sdk/proxywasm"
+)
+
+//go:embed directives
+var bundledDirectives embed.FS
+
+// defaultDirectivesURLsTimeoutMs is used when directives_urls_timeout_ms is unset or zero.
+const defaultDirectivesURLsTimeoutMs = 5000
+
+// resolveBundledDirectives reads each of the given directives_files, in order, from the wasm
+// module's embedded FS.
+func resolveBundledDirectives(names []string) ([]string, error) {
+	directives := make([]string, 0, len(names))
+	for _, name := range names {
+		content, err := bundledDirectives.ReadFile("directives/" + name)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading bundled directives file %q: %v", name, err)
+		}
+		directives = append(directives, string(content))
+	}
+	return directives, nil
+}
+
+// directivesURLFetch is one in-flight DispatchHttpCall issued to resolve a directives_urls entry.
+type directivesURLFetch struct {
+	url  string
+	done bool
+	body string
+	err  error
+}
+
+// directivesURLFetcher dispatches one HTTP GET per configured directives_urls entry against
+// cluster and collects the responses. It is driven from OnPluginStart (start) and from the
+// DispatchHttpCall callbacks built by onHttpCallResponse: OnPluginStart must not finish
+// compiling rulesets until allDone reports true, since the fetches complete asynchronously.
+type directivesURLFetcher struct {
+	cluster   string
+	timeoutMs uint32
+	byCallout map[uint32]*directivesURLFetch
+	fetches   []*directivesURLFetch
+}
+
+func newDirectivesURLFetcher(cluster string, timeoutMs int) *directivesURLFetcher {
+	if timeoutMs <= 0 {
+		timeoutMs = defaultDirectivesURLsTimeoutMs
+	}
+	return &directivesURLFetcher{
+		cluster:   cluster,
+		timeoutMs: uint32(timeoutMs),
+		byCallout: map[uint32]*directivesURLFetch{},
+	}
+}
+
+// calloutHeaders builds the pseudo-headers for an HTTP GET callout to rawURL: :path carries the
+// URL's path and query, :authority its host. The URL's scheme and port are not used: the request
+// is always sent through the Envoy cluster it's dispatched to.
+func calloutHeaders(rawURL string) ([][2]string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing directives url %q: %v", rawURL, err)
+	}
+
+	path := parsed.Path
+	if parsed.RawQuery != "" {
+		path += "?" + parsed.RawQuery
+	}
+
+	return [][2]string{{":method", "GET"}, {":path", path}, {":authority", parsed.Host}}, nil
+}
+
+// start dispatches one HTTP GET per url, each answered through a callback built by
+// onHttpCallResponse. It must be called once.
+func (f *directivesURLFetcher) start(urls []string) error {
+	if len(urls) > 0 && f.cluster == "" {
+		return fmt.Errorf("directives_urls is set but directives_urls_cluster is empty")
+	}
+	for _, rawURL := range urls {
+		fetch := &directivesURLFetch{url: rawURL}
+		f.fetches = append(f.fetches, fetch)
+
+		headers, err := calloutHeaders(rawURL)
+		if err != nil {
+			return err
+		}
+
+		calloutID, err := proxywasm.DispatchHttpCall(f.cluster, headers, nil, nil, f.timeoutMs, f.onHttpCallResponse(fetch))
+		if err != nil {
+			return fmt.Errorf("failed dispatching request for directives url %q: %v", rawURL, err)
+		}
+		f.byCallout[calloutID] = fetch
+	}
+	return nil
+}
+
+// checkCalloutStatus returns an error if headers carries a :status other than 200.
+func checkCalloutStatus(headers [][2]string, url string) error {
+	for _, h := range headers {
+		if h[0] == ":status" && h[1] != "200" {
+			return fmt.Errorf("fetching directives from %q returned status %s", url, h[1])
+		}
+	}
+	return nil
+}
+
+// onHttpCallResponse builds the callback DispatchHttpCall invokes once the given fetch's
+// response (or timeout) arrives.
+func (f *directivesURLFetcher) onHttpCallResponse(fetch *directivesURLFetch) func(numHeaders, bodySize, numTrailers int) {
+	return func(numHeaders, bodySize, numTrailers int) {
+		defer func() { fetch.done = true }()
+
+		headers, err := proxywasm.GetHttpCallResponseHeaders()
+		if err != nil {
+			fetch.err = fmt.Errorf("failed reading response headers for %q: %v", fetch.url, err)
+			return
+		}
+		if err := checkCalloutStatus(headers, fetch.url); err != nil {
+			fetch.err = err
+			return
+		}
+
+		body, err := proxywasm.GetHttpCallResponseBody(0, bodySize)
+		if err != nil {
+			fetch.err = fmt.Errorf("failed reading response body for %q: %v", fetch.url, err)
+			return
+		}
+		fetch.body = string(body)
+	}
+}
+
+// allDone reports whether every dispatched fetch has completed (successfully or not).
+func (f *directivesURLFetcher) allDone() bool {
+	for _, fetch := range f.fetches {
+		if !fetch.done {
+			return false
+		}
+	}
+	return true
+}
+
+// directives returns the fetched bodies in request order, or the first error encountered. It
+// must only be called once allDone reports true.
+func (f *directivesURLFetcher) directives() ([]string, error) {
+	directives := make([]string, 0, len(f.fetches))
+	for _, fetch := range f.fetches {
+		if fetch.err != nil {
+			return nil, fetch.err
+		}
+		directives = append(directives, fetch.body)
+	}
+	return directives, nil
+}
+
+// loadDirectives resolves config.directivesFiles and concatenates them with urlDirectives and
+// inlineRules in a deterministic order: files, then urls, then inline. urlDirectives must
+// already have been collected by a directivesURLFetcher once allDone reported true.
+func loadDirectives(config pluginConfiguration, urlDirectives []string, inlineRules []string) ([]string, error) {
+	fileDirectives, err := resolveBundledDirectives(config.directivesFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	directives := make([]string, 0, len(fileDirectives)+len(urlDirectives)+len(inlineRules))
+	directives = append(directives, fileDirectives...)
+	directives = append(directives, urlDirectives...)
+	directives = append(directives, inlineRules...)
+
+	return directives, nil
+}
@@ -0,0 +1,70 @@
+// Copyright The OWASP Coraza contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package wasmplugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveBundledDirectives(t *testing.T) {
+	t.Run("reads embedded files in order", func(t *testing.T) {
+		directives, err := resolveBundledDirectives([]string{"example.conf"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"SecRuleEngine On\n"}, directives)
+	})
+
+	t.Run("fails on an unknown file", func(t *testing.T) {
+		_, err := resolveBundledDirectives([]string{"does-not-exist.conf"})
+		assert.Error(t, err)
+	})
+}
+
+func TestCalloutHeaders(t *testing.T) {
+	t.Run("splits host into :authority and path+query into :path", func(t *testing.T) {
+		headers, err := calloutHeaders("https://example.com/custom-rules.conf?ref=main")
+		require.NoError(t, err)
+		assert.Equal(t, [][2]string{
+			{":method", "GET"},
+			{":path", "/custom-rules.conf?ref=main"},
+			{":authority", "example.com"},
+		}, headers)
+	})
+
+	t.Run("fails on an unparseable url", func(t *testing.T) {
+		_, err := calloutHeaders("://not-a-url")
+		assert.Error(t, err)
+	})
+}
+
+func TestCheckCalloutStatus(t *testing.T) {
+	t.Run("no error on 200", func(t *testing.T) {
+		err := checkCalloutStatus([][2]string{{":status", "200"}}, "https://example.com/custom-rules.conf")
+		assert.NoError(t, err)
+	})
+
+	t.Run("fails on a non-200 status", func(t *testing.T) {
+		err := checkCalloutStatus([][2]string{{":status", "404"}}, "https://example.com/custom-rules.conf")
+		assert.Error(t, err)
+	})
+}
+
+func TestLoadDirectives(t *testing.T) {
+	config := pluginConfiguration{
+		rules:           []string{"SecRule REQUEST_URI \"@streq /admin\" \"id:101,deny\""},
+		directivesFiles: []string{"example.conf"},
+	}
+
+	directives, err := loadDirectives(config, []string{"SecRule REQUEST_URI \"@streq /fetched\" \"id:102,deny\""}, config.rules)
+	require.NoError(t, err)
+
+	// files, then urls, then inline.
+	assert.Equal(t, []string{
+		"SecRuleEngine On\n",
+		"SecRule REQUEST_URI \"@streq /fetched\" \"id:102,deny\"",
+		"SecRule REQUEST_URI \"@streq /admin\" \"id:101,deny\"",
+	}, directives)
+}
@@ -0,0 +1,114 @@
+// Copyright The OWASP Coraza contributors
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build metrics || timing || memstats
+
+package wasmplugin
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tetratelabs/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tetratelabs/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+// phaseMetrics exports per-phase WAF timing as Envoy histograms and heap/gc stats as gauges,
+// through the proxy_define_metric/proxy_record_metric ABI, so operators get Prometheus-scrapeable
+// performance data via Envoy's stats sink instead of free-form log lines. It is built with any of
+// the "metrics", "timing" or "memstats" tags, and is the counterpart of the no-op implementation
+// in metrics_disabled.go.
+type phaseMetrics struct {
+	requestHeadersID  uint32
+	requestBodyID     uint32
+	responseHeadersID uint32
+	responseBodyID    uint32
+	ruleEvaluationID  uint32
+
+	heapSizeID uint32
+	gcCountID  uint32
+}
+
+// newPhaseMetrics defines one histogram per request-processing phase and one gauge per memstat,
+// each named with the ruleset's metric_labels so distinct rulesets report under distinct series.
+func newPhaseMetrics(metricLabels map[string]string) (*phaseMetrics, error) {
+	suffix := labelsSuffix(metricLabels)
+
+	defineHistogram := func(name string) (uint32, error) {
+		return proxywasm.DefineMetric(types.MetricTypeHistogram, fmt.Sprintf("coraza.%s.duration_ms%s", name, suffix))
+	}
+
+	m := &phaseMetrics{}
+	var err error
+	if m.requestHeadersID, err = defineHistogram("request_headers"); err != nil {
+		return nil, fmt.Errorf("failed defining request_headers histogram: %v", err)
+	}
+	if m.requestBodyID, err = defineHistogram("request_body"); err != nil {
+		return nil, fmt.Errorf("failed defining request_body histogram: %v", err)
+	}
+	if m.responseHeadersID, err = defineHistogram("response_headers"); err != nil {
+		return nil, fmt.Errorf("failed defining response_headers histogram: %v", err)
+	}
+	if m.responseBodyID, err = defineHistogram("response_body"); err != nil {
+		return nil, fmt.Errorf("failed defining response_body histogram: %v", err)
+	}
+	if m.ruleEvaluationID, err = defineHistogram("rule_evaluation"); err != nil {
+		return nil, fmt.Errorf("failed defining rule_evaluation histogram: %v", err)
+	}
+
+	if m.heapSizeID, err = proxywasm.DefineMetric(types.MetricTypeGauge, fmt.Sprintf("coraza.memstats.heap_size%s", suffix)); err != nil {
+		return nil, fmt.Errorf("failed defining heap_size gauge: %v", err)
+	}
+	if m.gcCountID, err = proxywasm.DefineMetric(types.MetricTypeGauge, fmt.Sprintf("coraza.memstats.gc_count%s", suffix)); err != nil {
+		return nil, fmt.Errorf("failed defining gc_count gauge: %v", err)
+	}
+
+	return m, nil
+}
+
+func (m *phaseMetrics) recordRequestHeaders(d time.Duration) {
+	_ = proxywasm.RecordMetric(m.requestHeadersID, d.Milliseconds())
+}
+
+func (m *phaseMetrics) recordRequestBody(d time.Duration) {
+	_ = proxywasm.RecordMetric(m.requestBodyID, d.Milliseconds())
+}
+
+func (m *phaseMetrics) recordResponseHeaders(d time.Duration) {
+	_ = proxywasm.RecordMetric(m.responseHeadersID, d.Milliseconds())
+}
+
+func (m *phaseMetrics) recordResponseBody(d time.Duration) {
+	_ = proxywasm.RecordMetric(m.responseBodyID, d.Milliseconds())
+}
+
+func (m *phaseMetrics) recordRuleEvaluation(d time.Duration) {
+	_ = proxywasm.RecordMetric(m.ruleEvaluationID, d.Milliseconds())
+}
+
+func (m *phaseMetrics) recordMemStats(heapSize, gcCount int64) {
+	_ = proxywasm.RecordMetric(m.heapSizeID, heapSize)
+	_ = proxywasm.RecordMetric(m.gcCountID, gcCount)
+}
+
+// labelsSuffix renders metricLabels as a deterministic ".key_value.key_value" suffix so Envoy's
+// stats sink exposes one series per distinct label set.
+func labelsSuffix(metricLabels map[string]string) string {
+	if len(metricLabels) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(metricLabels))
+	for k := range metricLabels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, ".%s_%s", k, metricLabels[k])
+	}
+	return b.String()
+}
@@ -0,0 +1,24 @@
+// Copyright The OWASP Coraza contributors
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !metrics && !timing && !memstats
+
+package wasmplugin
+
+import "time"
+
+// phaseMetrics is a no-op when built without any of the "metrics", "timing" or "memstats" tags,
+// so callers don't need to guard
+// every call site with a build tag of their own.
+type phaseMetrics struct{}
+
+func newPhaseMetrics(metricLabels map[string]string) (*phaseMetrics, error) {
+	return &phaseMetrics{}, nil
+}
+
+func (m *phaseMetrics) recordRequestHeaders(time.Duration)     {}
+func (m *phaseMetrics) recordRequestBody(time.Duration)        {}
+func (m *phaseMetrics) recordResponseHeaders(time.Duration)    {}
+func (m *phaseMetrics) recordResponseBody(time.Duration)       {}
+func (m *phaseMetrics) recordRuleEvaluation(time.Duration)     {}
+func (m *phaseMetrics) recordMemStats(heapSize, gcCount int64) {}
@@ -0,0 +1,34 @@
+// Copyright The OWASP Coraza contributors
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build metrics
+
+package wasmplugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLabelsSuffix(t *testing.T) {
+	testCases := []struct {
+		name   string
+		labels map[string]string
+		expect string
+	}{
+		{name: "no labels", labels: nil, expect: ""},
+		{name: "one label", labels: map[string]string{"owner": "coraza"}, expect: ".owner_coraza"},
+		{
+			name:   "multiple labels are sorted for determinism",
+			labels: map[string]string{"identifier": "global", "owner": "coraza"},
+			expect: ".identifier_global.owner_coraza",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expect, labelsSuffix(tc.labels))
+		})
+	}
+}
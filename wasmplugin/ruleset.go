@@ -0,0 +1,92 @@
+// Copyright The OWASP Coraza contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package wasmplugin
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/corazawaf/coraza/v3"
+	"github.com/tetratelabs/proxy-wasm-go-sdk/proxywasm"
+)
+
+// rulesetProperty is the Envoy route/filter metadata property consulted to pick a ruleset by
+// name before falling back to matching the ruleset name against :authority.
+var rulesetProperty = []string{"metadata", "filter_metadata", "envoy.filters.http.wasm", "coraza", "ruleset"}
+
+// rulesetRuntime is a compiled Coraza WAF instance paired with the metric labels configured for
+// its ruleset.
+type rulesetRuntime struct {
+	waf          coraza.WAF
+	metricLabels map[string]string
+}
+
+// rulesets caches every ruleset declared in a pluginConfiguration, compiled once so request
+// handling only ever does a map lookup.
+type rulesets struct {
+	byName map[string]*rulesetRuntime
+	def    string
+}
+
+// effectiveRules returns the directives to compile for the named ruleset. directives_files and
+// directives_urls are top-level config fields, not per-ruleset, so they apply only to config's
+// (implicit or explicit) default ruleset; every other ruleset compiles from its own rules
+// unchanged. urlDirectives must already have been collected by a directivesURLFetcher.
+func effectiveRules(config pluginConfiguration, name string, rs rulesetConfiguration, urlDirectives []string) ([]string, error) {
+	if name != config.defaultRuleset {
+		return rs.rules, nil
+	}
+	return loadDirectives(config, urlDirectives, rs.rules)
+}
+
+// newRulesets compiles every ruleset in config into a Coraza WAF instance, folding in
+// directives_files/directives_urls for the default ruleset. It is called once from
+// OnPluginStart, after any directivesURLFetcher has finished, and the result is cached for the
+// lifetime of the plugin.
+func newRulesets(config pluginConfiguration, urlDirectives []string) (*rulesets, error) {
+	r := &rulesets{byName: make(map[string]*rulesetRuntime, len(config.rulesets)), def: config.defaultRuleset}
+
+	for name, rs := range config.rulesets {
+		rules, err := effectiveRules(config, name, rs, urlDirectives)
+		if err != nil {
+			return nil, fmt.Errorf("failed loading directives for ruleset %q: %v", name, err)
+		}
+
+		wafConfig := coraza.NewWAFConfig().WithDirectives(strings.Join(rules, "\n"))
+		waf, err := coraza.NewWAF(wafConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed compiling ruleset %q: %v", name, err)
+		}
+		r.byName[name] = &rulesetRuntime{waf: waf, metricLabels: rs.metricLabels}
+	}
+
+	if _, ok := r.byName[r.def]; !ok {
+		return nil, fmt.Errorf("default_ruleset %q has no matching entry in rulesets", r.def)
+	}
+
+	return r, nil
+}
+
+// selectFor picks which compiled ruleset to run for the current request: first the route-level
+// "coraza.ruleset" metadata property, then a ruleset named after :authority, then the
+// configured default.
+func (r *rulesets) selectFor() (*rulesetRuntime, error) {
+	if name, err := proxywasm.GetProperty(rulesetProperty); err == nil {
+		if rt, ok := r.byName[string(name)]; ok {
+			return rt, nil
+		}
+	}
+
+	if authority, err := proxywasm.GetProperty([]string{"request", "authority"}); err == nil {
+		if rt, ok := r.byName[string(authority)]; ok {
+			return rt, nil
+		}
+	}
+
+	rt, ok := r.byName[r.def]
+	if !ok {
+		return nil, fmt.Errorf("no ruleset named %q is compiled", r.def)
+	}
+	return rt, nil
+}
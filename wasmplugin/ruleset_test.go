@@ -0,0 +1,87 @@
+// Copyright The OWASP Coraza contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package wasmplugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRulesets(t *testing.T) {
+	t.Run("compiles every ruleset and validates the default", func(t *testing.T) {
+		config, err := parsePluginConfiguration([]byte(`
+		{
+			"default_ruleset": "admin",
+			"rulesets": {
+				"admin": {"rules": ["SecRuleEngine On"]},
+				"public": {"rules": ["SecRuleEngine On"]}
+			}
+		}
+		`))
+		require.NoError(t, err)
+
+		rs, err := newRulesets(config, nil)
+		require.NoError(t, err)
+		assert.Len(t, rs.byName, 2)
+		assert.Contains(t, rs.byName, "admin")
+		assert.Contains(t, rs.byName, "public")
+		assert.Equal(t, "admin", rs.def)
+	})
+
+	t.Run("fails when default_ruleset has no matching entry", func(t *testing.T) {
+		config, err := parsePluginConfiguration([]byte(`
+		{
+			"default_ruleset": "missing",
+			"rulesets": {
+				"admin": {"rules": ["SecRuleEngine On"]}
+			}
+		}
+		`))
+		require.NoError(t, err)
+
+		_, err = newRulesets(config, nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("directives-only config compiles the implicit default ruleset", func(t *testing.T) {
+		config, err := parsePluginConfiguration([]byte(`
+		{
+			"directives_files": ["example.conf"]
+		}
+		`))
+		require.NoError(t, err)
+
+		rs, err := newRulesets(config, nil)
+		require.NoError(t, err)
+		assert.Contains(t, rs.byName, "default")
+		assert.Equal(t, "default", rs.def)
+	})
+}
+
+func TestEffectiveRules(t *testing.T) {
+	config, err := parsePluginConfiguration([]byte(`
+	{
+		"directives_files": ["example.conf"],
+		"rulesets": {
+			"default": {"rules": ["SecRuleEngine On"]},
+			"admin": {"rules": ["Include @admin-rules.conf"]}
+		}
+	}
+	`))
+	require.NoError(t, err)
+
+	t.Run("default ruleset gets directives_files and directives_urls prepended", func(t *testing.T) {
+		rules, err := effectiveRules(config, "default", config.rulesets["default"], []string{"fetched directive"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"SecRuleEngine On\n", "fetched directive", "SecRuleEngine On"}, rules)
+	})
+
+	t.Run("other rulesets are unaffected by directives_files/directives_urls", func(t *testing.T) {
+		rules, err := effectiveRules(config, "admin", config.rulesets["admin"], []string{"fetched directive"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Include @admin-rules.conf"}, rules)
+	})
+}